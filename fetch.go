@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3"
+	"golang.org/x/time/rate"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+)
+
+// fetchJob is one (metricType, resourceType) pair to pull time series for.
+type fetchJob struct {
+	metricType   string
+	resourceType string
+}
+
+// fetchAllConcurrently fans fetchTimeSeries calls for jobs out across a
+// worker pool bounded by concurrency, paced by a rateLimit requests-per-second
+// token bucket (Cloud Monitoring's ListTimeSeries quota defaults to 6/s per
+// project). Each job takes one token before its first ListTimeSeries request;
+// later result pages of the same job are not paced. The first worker error
+// cancels the shared context so siblings
+// stop promptly. Batches are funneled through a single writer goroutine
+// calling output, so concurrent workers never interleave output and formats
+// that require buffering (e.g. remote write) see every batch exactly once.
+func fetchAllConcurrently(
+	ctx context.Context,
+	client *monitoring.MetricClient,
+	projectID string,
+	jobs []fetchJob,
+	startTime, endTime time.Time,
+	aggregation *monitoringpb.Aggregation,
+	dropZeroBuckets bool,
+	concurrency int,
+	rateLimit float64,
+	output outputWriter,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	limiter := rate.NewLimiter(rate.Limit(rateLimit), 1)
+
+	batches := make(chan timeSeriesBatch, concurrency)
+	var writeWg sync.WaitGroup
+	writeWg.Add(1)
+	go func() {
+		defer writeWg.Done()
+		for batch := range batches {
+			if err := output.Write(batch.metricType, batch.points); err != nil {
+				log.Printf("write output: %v", err)
+				cancel()
+			}
+		}
+	}()
+
+	jobCh := make(chan fetchJob)
+	go func() {
+		defer close(jobCh)
+		for _, job := range jobs {
+			select {
+			case jobCh <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				if err := limiter.Wait(ctx); err != nil {
+					errOnce.Do(func() { firstErr = err })
+					cancel()
+					return
+				}
+
+				start := time.Now()
+				count, err := fetchTimeSeries(ctx, client, projectID, job.metricType, job.resourceType, startTime, endTime, aggregation, dropZeroBuckets, batches)
+				latency := time.Since(start)
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+					cancel()
+					return
+				}
+				log.Printf("fetched metricType=%s resourceType=%s points=%d latency=%s", job.metricType, job.resourceType, count, latency)
+			}
+		}()
+	}
+	wg.Wait()
+	close(batches)
+	writeWg.Wait()
+
+	if err := output.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	return firstErr
+}
@@ -0,0 +1,65 @@
+package main
+
+import "math"
+
+// computeBucketBoundaries materializes the upper edge of every bucket
+// described by bo, then truncates the result to len(numBucketCounts)-1 so it
+// always satisfies len(BucketBoundaries) == len(BucketCounts)-1 (the overflow
+// bucket has no finite upper edge) even though Cloud Monitoring may omit
+// trailing zero-count buckets from BucketCounts, making it shorter than
+// NumFiniteBuckets+2. Returns nil if bo has no options set.
+func computeBucketBoundaries(bo *BucketOptions, numBucketCounts int) []float64 {
+	if bo == nil || bo.Options == nil {
+		return nil
+	}
+
+	var bounds []float64
+	switch {
+	case bo.Options.LinearBuckets != nil:
+		lb := bo.Options.LinearBuckets
+		bounds = make([]float64, lb.NumFiniteBuckets+1)
+		for i := range bounds {
+			bounds[i] = lb.Offset + float64(i)*lb.Width
+		}
+	case bo.Options.ExponentialBuckets != nil:
+		eb := bo.Options.ExponentialBuckets
+		bounds = make([]float64, eb.NumFiniteBuckets+1)
+		for i := range bounds {
+			bounds[i] = eb.Scale * math.Pow(eb.GrowthFactor, float64(i))
+		}
+	case bo.Options.ExplicitBuckets != nil:
+		bounds = bo.Options.ExplicitBuckets.Bounds
+	default:
+		return nil
+	}
+
+	maxLen := numBucketCounts - 1
+	if maxLen < 0 {
+		maxLen = 0
+	}
+	if len(bounds) > maxLen {
+		bounds = bounds[:maxLen]
+	}
+	return bounds
+}
+
+// trimTrailingZeroBuckets drops trailing zero-count buckets, and their
+// corresponding boundaries, from a distribution's tail, mirroring
+// OpenCensus's option to exclude empty buckets from aggregation data. It
+// always leaves at least one bucket.
+func trimTrailingZeroBuckets(counts []int64, boundaries []float64) ([]int64, []float64) {
+	last := len(counts)
+	for last > 1 && counts[last-1] == 0 {
+		last--
+	}
+
+	boundaryLen := last - 1
+	if boundaryLen < 0 {
+		boundaryLen = 0
+	}
+	if boundaryLen > len(boundaries) {
+		boundaryLen = len(boundaries)
+	}
+
+	return counts[:last], boundaries[:boundaryLen]
+}
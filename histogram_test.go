@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputeBucketBoundaries(t *testing.T) {
+	tests := []struct {
+		name            string
+		bo              *BucketOptions
+		numBucketCounts int
+		want            []float64
+	}{
+		{
+			name: "linear buckets",
+			bo: &BucketOptions{Options: &OptionsUnion{LinearBuckets: &LinearBuckets{
+				NumFiniteBuckets: 3,
+				Width:            10,
+				Offset:           5,
+			}}},
+			numBucketCounts: 5,
+			want:            []float64{5, 15, 25, 35},
+		},
+		{
+			name: "exponential buckets",
+			bo: &BucketOptions{Options: &OptionsUnion{ExponentialBuckets: &ExponentialBuckets{
+				NumFiniteBuckets: 3,
+				GrowthFactor:     2,
+				Scale:            1,
+			}}},
+			numBucketCounts: 5,
+			want:            []float64{1, 2, 4, 8},
+		},
+		{
+			name: "explicit buckets",
+			bo: &BucketOptions{Options: &OptionsUnion{ExplicitBuckets: &ExplicitBuckets{
+				Bounds: []float64{1, 5, 10},
+			}}},
+			numBucketCounts: 4,
+			want:            []float64{1, 5, 10},
+		},
+		{
+			name: "truncated to match a shorter BucketCounts",
+			bo: &BucketOptions{Options: &OptionsUnion{LinearBuckets: &LinearBuckets{
+				NumFiniteBuckets: 3,
+				Width:            10,
+				Offset:           5,
+			}}},
+			numBucketCounts: 2,
+			want:            []float64{5},
+		},
+		{
+			name:            "no options set",
+			bo:              &BucketOptions{},
+			numBucketCounts: 5,
+			want:            nil,
+		},
+		{
+			name:            "nil BucketOptions",
+			bo:              nil,
+			numBucketCounts: 5,
+			want:            nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeBucketBoundaries(tt.bo, tt.numBucketCounts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("computeBucketBoundaries(%+v, %d) = %v, want %v", tt.bo, tt.numBucketCounts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimTrailingZeroBuckets(t *testing.T) {
+	tests := []struct {
+		name       string
+		counts     []int64
+		boundaries []float64
+		wantCounts []int64
+		wantBounds []float64
+	}{
+		{
+			name:       "no trailing zeros",
+			counts:     []int64{1, 2, 3},
+			boundaries: []float64{10, 20},
+			wantCounts: []int64{1, 2, 3},
+			wantBounds: []float64{10, 20},
+		},
+		{
+			name:       "trims trailing zero buckets and their boundaries",
+			counts:     []int64{1, 2, 0, 0},
+			boundaries: []float64{10, 20, 30},
+			wantCounts: []int64{1, 2},
+			wantBounds: []float64{10},
+		},
+		{
+			name:       "always leaves at least one bucket",
+			counts:     []int64{0, 0, 0},
+			boundaries: []float64{10, 20},
+			wantCounts: []int64{0},
+			wantBounds: []float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCounts, gotBounds := trimTrailingZeroBuckets(tt.counts, tt.boundaries)
+			if !reflect.DeepEqual(gotCounts, tt.wantCounts) {
+				t.Errorf("counts = %v, want %v", gotCounts, tt.wantCounts)
+			}
+			if !reflect.DeepEqual(gotBounds, tt.wantBounds) {
+				t.Errorf("boundaries = %v, want %v", gotBounds, tt.wantBounds)
+			}
+		})
+	}
+}
@@ -2,19 +2,33 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	monitoring "cloud.google.com/go/monitoring/apiv3"
+	"github.com/golang/protobuf/ptypes/duration"
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"google.golang.org/api/iterator"
 	"google.golang.org/genproto/googleapis/api/distribution"
 	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
 )
 
+// stringSliceFlag collects repeated occurrences of a flag into a slice,
+// e.g. -metricTypePrefixInclude=foo -metricTypePrefixInclude=bar.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 type KeyValue struct {
 	Type  string `json:"type"`
 	Key   string `json:"key"`
@@ -59,10 +73,15 @@ type DistributionValue struct {
 	Range                 *Range         `json:"range"`
 	BucketOptions         *BucketOptions `json:"bucket_options"`
 	BucketCounts          []int64        `json:"bucket_counts"`
+	// BucketBoundaries is the materialized upper edge of each bucket in
+	// BucketCounts except the last (overflow has no finite upper edge), so
+	// len(BucketBoundaries) == len(BucketCounts)-1.
+	BucketBoundaries []float64 `json:"bucket_boundaries"`
 }
 
 type Point struct {
 	Timestamp         time.Time          `json:"timestamp"`
+	EndTimestamp      *time.Time         `json:"end_timestamp,omitempty"`
 	Labels            []KeyValue         `json:"labels"`
 	BoolValue         *bool              `json:"bool_value"`
 	Int64Value        *int64             `json:"int64_value"`
@@ -79,23 +98,130 @@ func convertKeyValuePairs(labels map[string]string, _type string) []KeyValue {
 	return kvs
 }
 
-func readAndPrintTimeSeriesFields(
+// matchesMetricTypeFilters reports whether metricType should be fetched,
+// given the include/exclude prefix lists. An empty include list matches
+// everything; exclude is then applied on top and always wins.
+func matchesMetricTypeFilters(metricType string, includes, excludes []string) bool {
+	if len(includes) > 0 {
+		included := false
+		for _, prefix := range includes {
+			if strings.HasPrefix(metricType, prefix) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, prefix := range excludes {
+		if strings.HasPrefix(metricType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// listMatchingMetricTypes discovers all metric descriptors visible to
+// projectID and returns the metric.type values that pass the include/exclude
+// prefix filters.
+func listMatchingMetricTypes(ctx context.Context, client *monitoring.MetricClient, projectID string, includes, excludes []string) ([]string, error) {
+	req := &monitoringpb.ListMetricDescriptorsRequest{
+		Name: "projects/" + projectID,
+	}
+
+	var metricTypes []string
+	it := client.ListMetricDescriptors(ctx, req)
+	for {
+		resp, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not list metric descriptors: %v", err)
+		}
+		if matchesMetricTypeFilters(resp.GetType(), includes, excludes) {
+			metricTypes = append(metricTypes, resp.GetType())
+		}
+	}
+	return metricTypes, nil
+}
+
+// buildAggregation turns the -alignmentPeriod/-perSeriesAligner/
+// -crossSeriesReducer/-groupByField flags into a monitoringpb.Aggregation.
+// It returns nil if none of them were set, in which case the caller should
+// omit Aggregation from the request entirely and fall back to raw sampling.
+func buildAggregation(alignmentPeriod time.Duration, alignerName, reducerName string, groupByFields []string) (*monitoringpb.Aggregation, error) {
+	if alignmentPeriod == 0 && alignerName == "" && reducerName == "" && len(groupByFields) == 0 {
+		return nil, nil
+	}
+
+	agg := &monitoringpb.Aggregation{
+		AlignmentPeriod: &duration.Duration{Seconds: int64(alignmentPeriod.Seconds())},
+		GroupByFields:   groupByFields,
+	}
+
+	if alignerName != "" {
+		value, ok := monitoringpb.Aggregation_Aligner_value[alignerName]
+		if !ok {
+			return nil, fmt.Errorf("unknown perSeriesAligner: %s", alignerName)
+		}
+		agg.PerSeriesAligner = monitoringpb.Aggregation_Aligner(value)
+	}
+
+	if reducerName != "" {
+		value, ok := monitoringpb.Aggregation_Reducer_value[reducerName]
+		if !ok {
+			return nil, fmt.Errorf("unknown crossSeriesReducer: %s", reducerName)
+		}
+		agg.CrossSeriesReducer = monitoringpb.Aggregation_Reducer(value)
+	}
+
+	return agg, nil
+}
+
+// isRateOrDeltaAligner reports whether aligner collapses each point into a
+// rate or delta over the alignment period, in which case the point only
+// makes sense together with the end of that interval.
+func isRateOrDeltaAligner(aligner monitoringpb.Aggregation_Aligner) bool {
+	switch aligner {
+	case monitoringpb.Aggregation_ALIGN_RATE, monitoringpb.Aggregation_ALIGN_DELTA:
+		return true
+	default:
+		return false
+	}
+}
+
+// timeSeriesBatch holds the points of one GCP time series together with the
+// metric type they came from, so an outputWriter can name and label them.
+type timeSeriesBatch struct {
+	metricType string
+	points     []Point
+}
+
+// fetchTimeSeries reads the time series matching metricType/resourceType and
+// sends one timeSeriesBatch per GCP time series to out. It returns the total
+// number of points sent, for caller-side logging.
+func fetchTimeSeries(
 	ctx context.Context,
+	client *monitoring.MetricClient,
 	projectID string,
 	metricType string,
 	resourceType string,
 	startTime time.Time,
 	endTime time.Time,
-) error {
-	client, err := monitoring.NewMetricClient(ctx)
-	if err != nil {
-		return fmt.Errorf("NewMetricClient: %v", err)
+	aggregation *monitoringpb.Aggregation,
+	dropZeroBuckets bool,
+	out chan<- timeSeriesBatch,
+) (int, error) {
+	filter := fmt.Sprintf("metric.type=\"%s\"", metricType)
+	if resourceType != "" {
+		filter += fmt.Sprintf(" resource.type=\"%s\"", resourceType)
 	}
-	defer client.Close()
 
 	req := &monitoringpb.ListTimeSeriesRequest{
 		Name:   "projects/" + projectID,
-		Filter: fmt.Sprintf("metric.type=\"%s\" resource.type=\"%s\"", metricType, resourceType),
+		Filter: filter,
 		Interval: &monitoringpb.TimeInterval{
 			StartTime: &timestamp.Timestamp{
 				Seconds: startTime.Unix(),
@@ -104,9 +230,12 @@ func readAndPrintTimeSeriesFields(
 				Seconds: endTime.Unix(),
 			},
 		},
-		View: monitoringpb.ListTimeSeriesRequest_FULL,
+		Aggregation: aggregation,
+		View:        monitoringpb.ListTimeSeriesRequest_FULL,
 	}
 
+	totalPoints := 0
+
 	it := client.ListTimeSeries(ctx, req)
 	for {
 		resp, err := it.Next()
@@ -114,7 +243,7 @@ func readAndPrintTimeSeriesFields(
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("could not read time series value: %v", err)
+			return totalPoints, fmt.Errorf("could not read time series value: %v", err)
 		}
 
 		labels := make([]KeyValue, 0)
@@ -127,6 +256,10 @@ func readAndPrintTimeSeriesFields(
 				Timestamp: p.GetInterval().StartTime.AsTime(),
 				Labels:    labels,
 			}
+			if aggregation != nil && isRateOrDeltaAligner(aggregation.PerSeriesAligner) {
+				endTimestamp := p.GetInterval().EndTime.AsTime()
+				point.EndTimestamp = &endTimestamp
+			}
 			switch v := p.GetValue().GetValue().(type) {
 			case *monitoringpb.TypedValue_BoolValue:
 				point.BoolValue = &v.BoolValue
@@ -174,33 +307,98 @@ func readAndPrintTimeSeriesFields(
 					}
 				}
 				point.DistributionValue.BucketCounts = v.DistributionValue.GetBucketCounts()
+				point.DistributionValue.BucketBoundaries = computeBucketBoundaries(point.DistributionValue.BucketOptions, len(point.DistributionValue.BucketCounts))
+				if dropZeroBuckets {
+					point.DistributionValue.BucketCounts, point.DistributionValue.BucketBoundaries =
+						trimTrailingZeroBuckets(point.DistributionValue.BucketCounts, point.DistributionValue.BucketBoundaries)
+				}
 			default:
-				return fmt.Errorf("Not supported metric type: %s", v)
+				return totalPoints, fmt.Errorf("Not supported metric type: %s", v)
 			}
 			points = append(points, point)
 		}
+		totalPoints += len(points)
 
-		outputJson, err := json.Marshal(&points)
-		if err != nil {
-			return err
+		select {
+		case out <- timeSeriesBatch{metricType: metricType, points: points}:
+		case <-ctx.Done():
+			return totalPoints, ctx.Err()
 		}
-		fmt.Println(string(outputJson))
 	}
-	return nil
+	return totalPoints, nil
 }
 
 func main() {
 	var (
-		project      = flag.String("project", "", "GCP project")
-		metricType   = flag.String("metricType", "", "Type of metric")
-		resourceType = flag.String("resourceType", "", "Type of resource")
-		start        = flag.Int64("start", time.Now().Add(time.Duration(-10)*time.Minute).Unix(), "Start time (unix time)")
-		end          = flag.Int64("end", time.Now().Unix(), "End time (unix time)")
+		project                 = flag.String("project", "", "GCP project")
+		metricTypePrefixInclude stringSliceFlag
+		metricTypePrefixExclude stringSliceFlag
+		resourceTypes           stringSliceFlag
+		start                   = flag.Int64("start", time.Now().Add(time.Duration(-10)*time.Minute).Unix(), "Start time (unix time)")
+		end                     = flag.Int64("end", time.Now().Unix(), "End time (unix time)")
+		alignmentPeriod         = flag.Duration("alignmentPeriod", 0, "Alignment period for server-side aggregation (e.g. 60s); requires perSeriesAligner")
+		perSeriesAligner        = flag.String("perSeriesAligner", "", "Aligner to apply within each series, e.g. ALIGN_MEAN, ALIGN_RATE, ALIGN_DELTA")
+		crossSeriesReducer      = flag.String("crossSeriesReducer", "", "Reducer to combine series after alignment, e.g. REDUCE_MEAN, REDUCE_SUM")
+		groupByFields           stringSliceFlag
+		concurrency             = flag.Int("concurrency", 4, "Number of (metricType, resourceType) pairs to fetch concurrently")
+		rateLimit               = flag.Float64("rateLimit", 6, "Max (metricType, resourceType) fetches started per second; later result pages of a fetch are not paced (Cloud Monitoring's default per-project ListTimeSeries quota is 6)")
+		outputFormat            = flag.String("output", "json", "Output format: json, prom-text, or prom-remote-write")
+		remoteWriteURL          = flag.String("remoteWriteURL", "", "Remote-write endpoint URL; required when -output=prom-remote-write")
+		serveAddr               = flag.String("serve", "", "Run as a daemon serving the latest metrics on this address (e.g. :9100) instead of a one-shot fetch")
+		scrapeInterval          = flag.Duration("scrapeInterval", time.Minute, "How often to refetch metrics in -serve mode, and the trailing window each scrape covers")
+		dropZeroBuckets         = flag.Bool("dropZeroBuckets", false, "Omit trailing zero-count buckets from distribution BucketCounts and BucketBoundaries")
 	)
+	flag.Var(&metricTypePrefixInclude, "metricTypePrefixInclude", "Only fetch metric types with this prefix (repeatable; default: all)")
+	flag.Var(&metricTypePrefixExclude, "metricTypePrefixExclude", "Skip metric types with this prefix (repeatable; applied after include)")
+	flag.Var(&resourceTypes, "resourceType", "Type of resource to constrain the fetch to (repeatable; default: unconstrained)")
+	flag.Var(&groupByFields, "groupByField", "Field to group by when reducing across series (repeatable; requires crossSeriesReducer)")
 	flag.Parse()
 	ctx := context.Background()
 
-	if err := readAndPrintTimeSeriesFields(ctx, *project, *metricType, *resourceType, time.Unix(*start, 0), time.Unix(*end, 0)); err != nil {
+	aggregation, err := buildAggregation(*alignmentPeriod, *perSeriesAligner, *crossSeriesReducer, groupByFields)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	output, err := newOutputWriter(*outputFormat, *remoteWriteURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client, err := monitoring.NewMetricClient(ctx)
+	if err != nil {
+		log.Fatalf("NewMetricClient: %v", err)
+	}
+	defer client.Close()
+
+	if len(resourceTypes) == 0 {
+		resourceTypes = stringSliceFlag{""}
+	}
+
+	if *serveAddr != "" {
+		d := newDaemon()
+		go d.runScrapeLoop(ctx, client, *project, metricTypePrefixInclude, metricTypePrefixExclude, resourceTypes, aggregation, *dropZeroBuckets, *concurrency, *rateLimit, *scrapeInterval)
+
+		log.Printf("serving metrics on %s", *serveAddr)
+		if err := d.serve(ctx, *serveAddr); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	metricTypes, err := listMatchingMetricTypes(ctx, client, *project, metricTypePrefixInclude, metricTypePrefixExclude)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var jobs []fetchJob
+	for _, metricType := range metricTypes {
+		for _, resourceType := range resourceTypes {
+			jobs = append(jobs, fetchJob{metricType: metricType, resourceType: resourceType})
+		}
+	}
+
+	if err := fetchAllConcurrently(ctx, client, *project, jobs, time.Unix(*start, 0), time.Unix(*end, 0), aggregation, *dropZeroBuckets, *concurrency, *rateLimit, output); err != nil {
 		log.Fatal(err)
 	}
 }
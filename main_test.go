@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestMatchesMetricTypeFilters(t *testing.T) {
+	tests := []struct {
+		name       string
+		metricType string
+		includes   []string
+		excludes   []string
+		want       bool
+	}{
+		{
+			name:       "no filters matches everything",
+			metricType: "compute.googleapis.com/instance/cpu/utilization",
+			want:       true,
+		},
+		{
+			name:       "include prefix matches",
+			metricType: "compute.googleapis.com/instance/cpu/utilization",
+			includes:   []string{"compute.googleapis.com/"},
+			want:       true,
+		},
+		{
+			name:       "include prefix does not match",
+			metricType: "compute.googleapis.com/instance/cpu/utilization",
+			includes:   []string{"storage.googleapis.com/"},
+			want:       false,
+		},
+		{
+			name:       "any include prefix matching is sufficient",
+			metricType: "compute.googleapis.com/instance/cpu/utilization",
+			includes:   []string{"storage.googleapis.com/", "compute.googleapis.com/"},
+			want:       true,
+		},
+		{
+			name:       "exclude prefix wins over include",
+			metricType: "compute.googleapis.com/instance/cpu/utilization",
+			includes:   []string{"compute.googleapis.com/"},
+			excludes:   []string{"compute.googleapis.com/instance/cpu/"},
+			want:       false,
+		},
+		{
+			name:       "exclude prefix with no includes",
+			metricType: "compute.googleapis.com/instance/cpu/utilization",
+			excludes:   []string{"compute.googleapis.com/"},
+			want:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchesMetricTypeFilters(tt.metricType, tt.includes, tt.excludes)
+			if got != tt.want {
+				t.Errorf("matchesMetricTypeFilters(%q, %v, %v) = %v, want %v", tt.metricType, tt.includes, tt.excludes, got, tt.want)
+			}
+		})
+	}
+}
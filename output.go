@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// outputWriter receives one timeSeriesBatch's points at a time and is
+// responsible for rendering them in whichever format -output selected. It is
+// always driven from a single goroutine, so implementations don't need to be
+// safe for concurrent use.
+type outputWriter interface {
+	Write(metricType string, points []Point) error
+	Close() error
+}
+
+// newOutputWriter builds the outputWriter for the -output flag. kind is one
+// of "json" (default), "prom-text", or "prom-remote-write"; remoteWriteURL is
+// only required for the latter.
+func newOutputWriter(kind, remoteWriteURL string) (outputWriter, error) {
+	switch kind {
+	case "", "json":
+		return jsonOutputWriter{}, nil
+	case "prom-text":
+		return promTextOutputWriter{}, nil
+	case "prom-remote-write":
+		if remoteWriteURL == "" {
+			return nil, fmt.Errorf("-remoteWriteURL is required for -output=prom-remote-write")
+		}
+		return newPromRemoteWriteOutputWriter(remoteWriteURL), nil
+	default:
+		return nil, fmt.Errorf("unknown -output format: %s", kind)
+	}
+}
+
+// jsonOutputWriter reproduces the tool's original behavior: one JSON array of
+// Point per GCP time series, printed to stdout.
+type jsonOutputWriter struct{}
+
+func (jsonOutputWriter) Write(metricType string, points []Point) error {
+	outputJson, err := json.Marshal(&points)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(outputJson))
+	return nil
+}
+
+func (jsonOutputWriter) Close() error { return nil }
+
+// promTextOutputWriter prints samples in Prometheus text exposition format.
+type promTextOutputWriter struct{}
+
+func (promTextOutputWriter) Write(metricType string, points []Point) error {
+	for _, p := range points {
+		for _, sample := range promSamplesForPoint(metricType, p) {
+			fmt.Println(sample.textLine())
+		}
+	}
+	return nil
+}
+
+func (promTextOutputWriter) Close() error { return nil }
+
+// textLine renders a promSample as one line of Prometheus text exposition
+// format: name{label="value",...} value timestamp_ms.
+func (s promSample) textLine() string {
+	keys := make([]string, 0, len(s.labels))
+	for k := range s.labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	labelPairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		labelPairs = append(labelPairs, fmt.Sprintf("%s=%q", k, s.labels[k]))
+	}
+
+	labelStr := ""
+	if len(labelPairs) > 0 {
+		labelStr = "{" + strings.Join(labelPairs, ",") + "}"
+	}
+
+	return fmt.Sprintf("%s%s %s %d", s.name, labelStr, strconv.FormatFloat(s.value, 'g', -1, 64), s.timestampMs)
+}
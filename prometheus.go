@@ -0,0 +1,99 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+)
+
+var (
+	nonPromNameChars  = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	nonPromLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// promMetricName turns a GCP metric type such as
+// "compute.googleapis.com/instance/cpu/utilization" into a valid Prometheus
+// metric name by replacing every non [a-zA-Z0-9_:] character with "_".
+func promMetricName(metricType string) string {
+	return nonPromNameChars.ReplaceAllString(metricType, "_")
+}
+
+// promLabelName sanitizes a GCP label key into a valid Prometheus label name.
+func promLabelName(key string) string {
+	return nonPromLabelChars.ReplaceAllString(key, "_")
+}
+
+// promSample is one Prometheus sample: a fully-qualified metric name, its
+// label set (excluding __name__), a value, and a millisecond timestamp.
+type promSample struct {
+	name        string
+	labels      map[string]string
+	value       float64
+	timestampMs int64
+}
+
+// promSamplesForPoint converts a single GCP Point into the Prometheus
+// sample(s) it represents. Scalar values map to one sample; distributions
+// expand into the standard count/sum/bucket histogram triple. String values
+// have no Prometheus equivalent and are dropped.
+func promSamplesForPoint(metricType string, p Point) []promSample {
+	name := promMetricName(metricType)
+	labels := make(map[string]string, len(p.Labels))
+	for _, kv := range p.Labels {
+		labels[promLabelName(kv.Type+"_"+kv.Key)] = kv.Value
+	}
+	timestampMs := p.Timestamp.UnixNano() / int64(1e6)
+
+	switch {
+	case p.BoolValue != nil:
+		value := 0.0
+		if *p.BoolValue {
+			value = 1
+		}
+		return []promSample{{name: name, labels: labels, value: value, timestampMs: timestampMs}}
+	case p.Int64Value != nil:
+		return []promSample{{name: name, labels: labels, value: float64(*p.Int64Value), timestampMs: timestampMs}}
+	case p.DoubleValue != nil:
+		return []promSample{{name: name, labels: labels, value: *p.DoubleValue, timestampMs: timestampMs}}
+	case p.DistributionValue != nil:
+		return promHistogramSamples(name, labels, timestampMs, p.DistributionValue)
+	default:
+		return nil
+	}
+}
+
+// promHistogramSamples expands a GCP DistributionValue into the Prometheus
+// histogram triple: one _count sample, one _sum sample (mean * count), and
+// one cumulative _bucket{le="..."} sample per upper bound, with the final
+// bucket carrying le="+Inf" and the total count.
+func promHistogramSamples(name string, labels map[string]string, timestampMs int64, dv *DistributionValue) []promSample {
+	samples := make([]promSample, 0, len(dv.BucketCounts)+2)
+	samples = append(samples, promSample{name: name + "_count", labels: labels, value: float64(dv.Count), timestampMs: timestampMs})
+	samples = append(samples, promSample{name: name + "_sum", labels: labels, value: dv.Mean * float64(dv.Count), timestampMs: timestampMs})
+
+	// Use the already-materialized BucketBoundaries rather than recomputing
+	// from BucketOptions: -dropZeroBuckets trims BucketCounts and
+	// BucketBoundaries together, and only the materialized slice preserves the
+	// len(BucketBoundaries) == len(BucketCounts)-1 invariant the loop below
+	// relies on to place the final le="+Inf" sample.
+	bounds := dv.BucketBoundaries
+
+	cumulative := int64(0)
+	for i, count := range dv.BucketCounts {
+		cumulative += count
+
+		le := "+Inf"
+		if i < len(bounds) {
+			le = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+		}
+
+		bucketLabels := make(map[string]string, len(labels)+1)
+		for k, v := range labels {
+			bucketLabels[k] = v
+		}
+		bucketLabels["le"] = le
+
+		samples = append(samples, promSample{name: name + "_bucket", labels: bucketLabels, value: float64(cumulative), timestampMs: timestampMs})
+	}
+
+	return samples
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestPromMetricName(t *testing.T) {
+	tests := []struct {
+		metricType string
+		want       string
+	}{
+		{
+			metricType: "compute.googleapis.com/instance/cpu/utilization",
+			want:       "compute_googleapis_com_instance_cpu_utilization",
+		},
+		{
+			metricType: "custom.googleapis.com/my-metric:v2",
+			want:       "custom_googleapis_com_my_metric:v2",
+		},
+		{
+			metricType: "already_valid_name",
+			want:       "already_valid_name",
+		},
+	}
+
+	for _, tt := range tests {
+		got := promMetricName(tt.metricType)
+		if got != tt.want {
+			t.Errorf("promMetricName(%q) = %q, want %q", tt.metricType, got, tt.want)
+		}
+	}
+}
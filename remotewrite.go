@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// remoteWriteBatchSize caps how many series go into a single remote-write
+// request, per the Prometheus remote-write convention.
+const remoteWriteBatchSize = 500
+
+// promRemoteWriteOutputWriter buffers samples into prompb.TimeSeries and
+// POSTs them to a Prometheus remote-write endpoint in snappy-compressed
+// protobuf batches, retrying on 5xx with exponential backoff.
+type promRemoteWriteOutputWriter struct {
+	url        string
+	httpClient *http.Client
+	batch      []prompb.TimeSeries
+}
+
+func newPromRemoteWriteOutputWriter(url string) *promRemoteWriteOutputWriter {
+	return &promRemoteWriteOutputWriter{
+		url:        url,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Write groups every sample produced from points by its label set (GCP
+// returns a series' points newest-first, and a distribution point expands
+// into several label sets, one per bucket) before appending one TimeSeries
+// per group to the batch. Samples within a group are sorted ascending by
+// timestamp, since remote-write receivers like Prometheus/Cortex reject
+// out-of-order samples within a series.
+func (w *promRemoteWriteOutputWriter) Write(metricType string, points []Point) error {
+	groups := make(map[string]*prompb.TimeSeries)
+	var order []string
+
+	for _, p := range points {
+		for _, sample := range promSamplesForPoint(metricType, p) {
+			key := sample.seriesKey()
+			ts, ok := groups[key]
+			if !ok {
+				ts = &prompb.TimeSeries{Labels: sample.promLabels()}
+				groups[key] = ts
+				order = append(order, key)
+			}
+			ts.Samples = append(ts.Samples, prompb.Sample{Value: sample.value, Timestamp: sample.timestampMs})
+		}
+	}
+
+	for _, key := range order {
+		ts := groups[key]
+		sort.Slice(ts.Samples, func(i, j int) bool { return ts.Samples[i].Timestamp < ts.Samples[j].Timestamp })
+
+		w.batch = append(w.batch, *ts)
+		if len(w.batch) >= remoteWriteBatchSize {
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *promRemoteWriteOutputWriter) Close() error {
+	return w.flush()
+}
+
+func (w *promRemoteWriteOutputWriter) flush() error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+
+	req := &prompb.WriteRequest{Timeseries: w.batch}
+	w.batch = w.batch[:0]
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal remote-write request: %v", err)
+	}
+
+	return w.postWithRetry(snappy.Encode(nil, data))
+}
+
+func (w *promRemoteWriteOutputWriter) postWithRetry(body []byte) error {
+	const maxAttempts = 5
+	backoff := 500 * time.Millisecond
+
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		httpReq, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("build remote-write request: %v", err)
+		}
+		httpReq.Header.Set("Content-Encoding", "snappy")
+		httpReq.Header.Set("Content-Type", "application/x-protobuf")
+		httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+		resp, err := w.httpClient.Do(httpReq)
+		if err != nil {
+			lastErr = err
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode < 500 {
+				if resp.StatusCode >= 300 {
+					return fmt.Errorf("remote write rejected with status %d", resp.StatusCode)
+				}
+				return nil
+			}
+			lastErr = fmt.Errorf("remote write failed with status %d", resp.StatusCode)
+		}
+
+		if attempt < maxAttempts-1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return fmt.Errorf("remote write: giving up after %d attempts: %v", maxAttempts, lastErr)
+}
+
+// seriesKey identifies the Prometheus series a sample belongs to: its
+// metric name plus its full label set, independent of map iteration order.
+func (s promSample) seriesKey() string {
+	keys := make([]string, 0, len(s.labels))
+	for k := range s.labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(s.name)
+	for _, k := range keys {
+		b.WriteByte('\x00')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(s.labels[k])
+	}
+	return b.String()
+}
+
+// promLabels renders the sample's label set as prompb.Label, materializing
+// the metric name as the reserved __name__ label, sorted lexicographically by
+// name as the Prometheus remote-write spec requires.
+func (s promSample) promLabels() []prompb.Label {
+	labels := make([]prompb.Label, 0, len(s.labels)+1)
+	labels = append(labels, prompb.Label{Name: "__name__", Value: s.name})
+	for k, v := range s.labels {
+		labels = append(labels, prompb.Label{Name: k, Value: v})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
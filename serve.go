@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+)
+
+// memoryOutputWriter is the outputWriter used in -serve mode: it buffers one
+// scrape's batches in memory instead of printing them, then exposes the
+// latest finished scrape for GET /metrics and /metrics/json to read. A scrape
+// whose fetch ends with an error still publishes the batches written before
+// the error.
+type memoryOutputWriter struct {
+	mu      sync.Mutex
+	pending map[string][]Point
+	current atomic.Value // map[string][]Point
+}
+
+func newMemoryOutputWriter() *memoryOutputWriter {
+	w := &memoryOutputWriter{pending: make(map[string][]Point)}
+	w.current.Store(map[string][]Point{})
+	return w
+}
+
+func (w *memoryOutputWriter) Write(metricType string, points []Point) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending[metricType] = append(w.pending[metricType], points...)
+	return nil
+}
+
+// Close is called once a scrape's batches have all been written; it
+// publishes the pending scrape as the current snapshot and starts a fresh
+// one for next time.
+func (w *memoryOutputWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.current.Store(w.pending)
+	w.pending = make(map[string][]Point)
+	return nil
+}
+
+func (w *memoryOutputWriter) snapshot() map[string][]Point {
+	return w.current.Load().(map[string][]Point)
+}
+
+// daemon backs -serve mode: it owns the in-memory metric store, the
+// self-observability counters, and the HTTP endpoints that expose both.
+type daemon struct {
+	store *memoryOutputWriter
+
+	fetchRequestsTotal int64
+	fetchErrorsTotal   int64
+
+	durationMu    sync.Mutex
+	durationCount int64
+	durationSum   float64
+}
+
+func newDaemon() *daemon {
+	return &daemon{store: newMemoryOutputWriter()}
+}
+
+func (d *daemon) recordFetch(duration time.Duration, err error) {
+	atomic.AddInt64(&d.fetchRequestsTotal, 1)
+	if err != nil {
+		atomic.AddInt64(&d.fetchErrorsTotal, 1)
+	}
+
+	d.durationMu.Lock()
+	d.durationCount++
+	d.durationSum += duration.Seconds()
+	d.durationMu.Unlock()
+}
+
+// runScrapeLoop refetches the configured metrics for the trailing
+// [now-scrapeInterval, now] window every scrapeInterval, publishing each
+// scrape's results to d.store. It runs until ctx is canceled.
+func (d *daemon) runScrapeLoop(
+	ctx context.Context,
+	client *monitoring.MetricClient,
+	projectID string,
+	metricTypePrefixInclude, metricTypePrefixExclude, resourceTypes []string,
+	aggregation *monitoringpb.Aggregation,
+	dropZeroBuckets bool,
+	concurrency int,
+	rateLimit float64,
+	scrapeInterval time.Duration,
+) {
+	scrape := func() {
+		start := time.Now()
+
+		metricTypes, err := listMatchingMetricTypes(ctx, client, projectID, metricTypePrefixInclude, metricTypePrefixExclude)
+		if err != nil {
+			log.Printf("scrape: list metric types: %v", err)
+			d.recordFetch(time.Since(start), err)
+			return
+		}
+
+		var jobs []fetchJob
+		for _, metricType := range metricTypes {
+			for _, resourceType := range resourceTypes {
+				jobs = append(jobs, fetchJob{metricType: metricType, resourceType: resourceType})
+			}
+		}
+
+		err = fetchAllConcurrently(ctx, client, projectID, jobs, start.Add(-scrapeInterval), start, aggregation, dropZeroBuckets, concurrency, rateLimit, d.store)
+		d.recordFetch(time.Since(start), err)
+		if err != nil {
+			log.Printf("scrape: %v", err)
+		}
+	}
+
+	scrape()
+
+	ticker := time.NewTicker(scrapeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scrape()
+		}
+	}
+}
+
+// serve starts the HTTP server for GET /metrics and GET /metrics/json,
+// shutting down when ctx is canceled.
+func (d *daemon) serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", d.handleMetrics)
+	mux.HandleFunc("/metrics/json", d.handleMetricsJSON)
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (d *daemon) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	for metricType, points := range d.store.snapshot() {
+		for _, p := range points {
+			for _, sample := range promSamplesForPoint(metricType, p) {
+				fmt.Fprintln(w, sample.textLine())
+			}
+		}
+	}
+	fmt.Fprint(w, d.selfMetricsText())
+}
+
+// selfMetricsText renders cmmf_fetch_requests_total, cmmf_fetch_errors_total,
+// and cmmf_fetch_duration_seconds as a minimal Prometheus summary (count and
+// sum only), analogous to how Telegraf's stackdriver input tracks its own
+// gather latency.
+func (d *daemon) selfMetricsText() string {
+	requests := atomic.LoadInt64(&d.fetchRequestsTotal)
+	errors := atomic.LoadInt64(&d.fetchErrorsTotal)
+
+	d.durationMu.Lock()
+	count, sum := d.durationCount, d.durationSum
+	d.durationMu.Unlock()
+
+	return fmt.Sprintf(
+		"cmmf_fetch_requests_total %d\ncmmf_fetch_errors_total %d\ncmmf_fetch_duration_seconds_count %d\ncmmf_fetch_duration_seconds_sum %g\n",
+		requests, errors, count, sum,
+	)
+}
+
+func (d *daemon) handleMetricsJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d.store.snapshot()); err != nil {
+		log.Printf("encode /metrics/json response: %v", err)
+	}
+}